@@ -0,0 +1,182 @@
+// Package evidence implements types.EvidencePool: a store of validator
+// misbehaviour evidence that has been verified against history but not yet
+// committed in a block.
+package evidence
+
+import (
+	"fmt"
+	"sync"
+
+	"gitlab.33.cn/chain33/chain33/consensus/drivers/tendermint/state"
+	"gitlab.33.cn/chain33/chain33/consensus/drivers/tendermint/types"
+)
+
+// EvidencePool verifies submitted evidence against the validator set that
+// was active at the height it's about, holds it until it's been included
+// in a committed block, and prunes it once it's older than the chain's
+// EvidenceParams.MaxAge.
+type EvidencePool struct {
+	mtx     sync.Mutex
+	stateDB *state.CSStateDB
+	chainID string
+
+	pending   []types.Evidence
+	committed map[string]int64 // evidence hash -> height committed at
+}
+
+// NewEvidencePool returns an EvidencePool backed by stateDB, used to look
+// up the validator set active at the height any submitted evidence is
+// about. chainID is needed to reconstruct the sign-bytes of the votes
+// carried by DuplicateVoteEvidence so their signatures can be verified.
+func NewEvidencePool(stateDB *state.CSStateDB, chainID string) *EvidencePool {
+	return &EvidencePool{
+		stateDB:   stateDB,
+		chainID:   chainID,
+		committed: make(map[string]int64),
+	}
+}
+
+// AddEvidence verifies ev against the validator set active at ev.Height()
+// and, if it checks out and isn't already known, adds it to the pending
+// set so it can be gossiped and eventually included in a block.
+func (evpool *EvidencePool) AddEvidence(ev types.Evidence) error {
+	if evpool.IsCommitted(ev) {
+		return fmt.Errorf("evidence was already committed: %v", ev)
+	}
+	if evpool.IsPending(ev) {
+		return nil
+	}
+
+	valSet, err := evpool.stateDB.LoadValidators(ev.Height())
+	if err != nil {
+		return fmt.Errorf("failed to verify evidence: %v", err)
+	}
+	_, val := valSet.GetByAddress(ev.Address())
+	if val == nil {
+		return fmt.Errorf("evidence was from an unknown validator %X at height %d", ev.Address(), ev.Height())
+	}
+
+	switch dve := ev.(type) {
+	case *types.DuplicateVoteEvidence:
+		if err := verifyDuplicateVote(dve, val, evpool.chainID); err != nil {
+			return fmt.Errorf("invalid duplicate-vote evidence: %v", err)
+		}
+	}
+
+	evpool.mtx.Lock()
+	defer evpool.mtx.Unlock()
+	evpool.pending = append(evpool.pending, ev)
+	return nil
+}
+
+// PendingEvidence returns up to maxNum pieces of verified evidence that
+// haven't been committed yet, for consensus to include in a proposed
+// block. maxNum <= 0 returns all of it.
+func (evpool *EvidencePool) PendingEvidence(maxNum int) []types.Evidence {
+	evpool.mtx.Lock()
+	defer evpool.mtx.Unlock()
+
+	if maxNum <= 0 || maxNum > len(evpool.pending) {
+		maxNum = len(evpool.pending)
+	}
+	out := make([]types.Evidence, maxNum)
+	copy(out, evpool.pending[:maxNum])
+	return out
+}
+
+// IsPending reports whether ev is already held as pending evidence.
+func (evpool *EvidencePool) IsPending(ev types.Evidence) bool {
+	evpool.mtx.Lock()
+	defer evpool.mtx.Unlock()
+	for _, p := range evpool.pending {
+		if p.Equal(ev) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsCommitted reports whether ev has already been committed in a block.
+func (evpool *EvidencePool) IsCommitted(ev types.Evidence) bool {
+	evpool.mtx.Lock()
+	defer evpool.mtx.Unlock()
+	_, ok := evpool.committed[string(ev.Hash())]
+	return ok
+}
+
+// Update marks every piece of evidence carried by block as committed,
+// removes it from pending, and prunes evidence older than the chain's
+// current EvidenceParams.MaxAge.
+func (evpool *EvidencePool) Update(block *types.Block) {
+	evpool.mtx.Lock()
+	defer evpool.mtx.Unlock()
+
+	for _, ev := range block.Evidence.Evidence {
+		evpool.committed[string(ev.Hash())] = block.Header.Height
+		evpool.removePendingLocked(ev)
+	}
+
+	params, err := evpool.stateDB.LoadConsensusParams(block.Header.Height)
+	if err != nil {
+		return
+	}
+	evpool.prunedLocked(block.Header.Height, params.EvidenceParams.MaxAge)
+}
+
+func (evpool *EvidencePool) removePendingLocked(ev types.Evidence) {
+	for i, p := range evpool.pending {
+		if p.Equal(ev) {
+			evpool.pending = append(evpool.pending[:i], evpool.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+func (evpool *EvidencePool) prunedLocked(height, maxAge int64) {
+	for hash, committedHeight := range evpool.committed {
+		if height-committedHeight > maxAge {
+			delete(evpool.committed, hash)
+		}
+	}
+	fresh := evpool.pending[:0]
+	for _, ev := range evpool.pending {
+		if height-ev.Height() <= maxAge {
+			fresh = append(fresh, ev)
+		}
+	}
+	evpool.pending = fresh
+}
+
+// verifyDuplicateVote checks that the two votes in dve genuinely conflict
+// and were both actually cast by the accused validator: same height, round
+// and type, different block IDs, both signed by val's key and both
+// attributed to dve.ValidatorAddress. Without this, anyone could wrap two
+// arbitrary votes around any known validator's address and have it accepted
+// as evidence against them.
+func verifyDuplicateVote(dve *types.DuplicateVoteEvidence, val *types.Validator, chainID string) error {
+	voteA, voteB := dve.VoteA, dve.VoteB
+
+	if string(voteA.ValidatorAddress) != string(dve.ValidatorAddress) ||
+		string(voteB.ValidatorAddress) != string(dve.ValidatorAddress) {
+		return fmt.Errorf("votes are not both attributed to the accused validator %X", dve.ValidatorAddress)
+	}
+	if voteA.Height != voteB.Height {
+		return fmt.Errorf("votes are for different heights: %d vs %d", voteA.Height, voteB.Height)
+	}
+	if voteA.Round != voteB.Round {
+		return fmt.Errorf("votes are for different rounds: %d vs %d", voteA.Round, voteB.Round)
+	}
+	if voteA.Type != voteB.Type {
+		return fmt.Errorf("votes are for different types: %v vs %v", voteA.Type, voteB.Type)
+	}
+	if string(voteA.BlockID.Hash) == string(voteB.BlockID.Hash) {
+		return fmt.Errorf("votes are for the same block, not a conflict")
+	}
+	if !val.PubKey.VerifyBytes(voteA.SignBytes(chainID), voteA.Signature) {
+		return fmt.Errorf("voteA has an invalid signature")
+	}
+	if !val.PubKey.VerifyBytes(voteB.SignBytes(chainID), voteB.Signature) {
+		return fmt.Errorf("voteB has an invalid signature")
+	}
+	return nil
+}
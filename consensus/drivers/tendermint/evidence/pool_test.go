@@ -0,0 +1,112 @@
+package evidence
+
+import (
+	"testing"
+
+	"gitlab.33.cn/chain33/chain33/consensus/drivers/tendermint/types"
+)
+
+// fakePubKey lets a test control exactly which sign-bytes/signature pairs
+// verify, without needing a real signing scheme.
+type fakePubKey struct {
+	address []byte
+	valid   map[string]bool // signBytes + "|" + signature (as string) -> whether it verifies
+}
+
+func (k *fakePubKey) Address() []byte { return k.address }
+
+func (k *fakePubKey) VerifyBytes(msg []byte, sig []byte) bool {
+	return k.valid[string(msg)+"|"+string(sig)]
+}
+
+func vote(validator []byte, height int64, round int, voteType byte, blockHash []byte, sig []byte) *types.Vote {
+	return &types.Vote{
+		ValidatorAddress: validator,
+		Height:           height,
+		Round:            round,
+		Type:             voteType,
+		BlockID:          types.BlockID{Hash: blockHash},
+		Signature:        sig,
+	}
+}
+
+// TestVerifyDuplicateVoteRejectsForgedAttribution is the regression test
+// for the bug where AddEvidence accepted a DuplicateVoteEvidence naming any
+// known validator even though neither vote was actually cast by them.
+func TestVerifyDuplicateVoteRejectsForgedAttribution(t *testing.T) {
+	accused := []byte("validator-A")
+	framer := []byte("validator-B")
+
+	val := &types.Validator{
+		Address: accused,
+		PubKey: &fakePubKey{
+			address: accused,
+			valid:   map[string]bool{}, // nothing verifies; forged votes must be rejected before signatures even matter
+		},
+	}
+
+	dve := &types.DuplicateVoteEvidence{
+		ValidatorAddress: accused,
+		VoteA:            vote(framer, 100, 0, 1, []byte("block-1"), []byte("sig-1")),
+		VoteB:            vote(framer, 100, 0, 1, []byte("block-2"), []byte("sig-2")),
+	}
+
+	if err := verifyDuplicateVote(dve, val, "test-chain"); err == nil {
+		t.Fatal("expected votes cast by a different validator to be rejected")
+	}
+}
+
+// TestVerifyDuplicateVoteRejectsBadSignature ensures a vote that is
+// correctly attributed but not actually signed by the validator's key is
+// rejected.
+func TestVerifyDuplicateVoteRejectsBadSignature(t *testing.T) {
+	accused := []byte("validator-A")
+	val := &types.Validator{
+		Address: accused,
+		PubKey: &fakePubKey{
+			address: accused,
+			valid:   map[string]bool{}, // no signature verifies
+		},
+	}
+
+	dve := &types.DuplicateVoteEvidence{
+		ValidatorAddress: accused,
+		VoteA:            vote(accused, 100, 0, 1, []byte("block-1"), []byte("sig-1")),
+		VoteB:            vote(accused, 100, 0, 1, []byte("block-2"), []byte("sig-2")),
+	}
+
+	if err := verifyDuplicateVote(dve, val, "test-chain"); err == nil {
+		t.Fatal("expected a vote with an invalid signature to be rejected")
+	}
+}
+
+// TestVerifyDuplicateVoteAcceptsGenuineConflict is the positive case: two
+// votes genuinely cast by the accused validator, for the same height and
+// round, over different blocks, both with valid signatures.
+func TestVerifyDuplicateVoteAcceptsGenuineConflict(t *testing.T) {
+	accused := []byte("validator-A")
+	chainID := "test-chain"
+	voteA := vote(accused, 100, 0, 1, []byte("block-1"), []byte("sig-1"))
+	voteB := vote(accused, 100, 0, 1, []byte("block-2"), []byte("sig-2"))
+
+	val := &types.Validator{
+		Address: accused,
+		PubKey: &fakePubKey{
+			address: accused,
+			valid: map[string]bool{
+				string(voteA.SignBytes(chainID)) + "|sig-1": true,
+				string(voteB.SignBytes(chainID)) + "|sig-2": true,
+			},
+		},
+	}
+
+	dve := &types.DuplicateVoteEvidence{
+		ValidatorAddress: accused,
+		VoteA:            voteA,
+		VoteB:            voteB,
+	}
+
+	if err := verifyDuplicateVote(dve, val, chainID); err != nil {
+		t.Fatalf("expected a genuine duplicate vote to verify, got: %v", err)
+	}
+}
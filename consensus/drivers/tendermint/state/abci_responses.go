@@ -0,0 +1,38 @@
+package state
+
+import (
+	"crypto/sha256"
+
+	"gitlab.33.cn/chain33/chain33/consensus/drivers/tendermint/types"
+)
+
+// ABCIResponses retains the per-tx and per-block results produced while
+// executing a block against the app, so ApplyBlock can fold them into the
+// next State and so they can be persisted and replayed without
+// re-executing the block.
+type ABCIResponses struct {
+	DeliverTx  []*types.ResponseDeliverTx
+	EndBlock   *types.ResponseEndBlock
+	BeginBlock *types.ResponseBeginBlock
+}
+
+// NewABCIResponses returns ABCIResponses sized for the number of txs in block.
+func NewABCIResponses(block *types.Block) *ABCIResponses {
+	return &ABCIResponses{
+		DeliverTx: make([]*types.ResponseDeliverTx, len(block.Data.Txs)),
+	}
+}
+
+// ResultsHash returns a deterministic hash over the DeliverTx results, used
+// to populate State.LastResultsHash so light clients can verify a tx result
+// against the header without re-executing the block.
+func (a *ABCIResponses) ResultsHash() []byte {
+	h := sha256.New()
+	for _, res := range a.DeliverTx {
+		if res == nil {
+			continue
+		}
+		h.Write(res.Data)
+	}
+	return h.Sum(nil)
+}
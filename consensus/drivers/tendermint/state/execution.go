@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	log "github.com/inconshreveable/log15"
+	"gitlab.33.cn/chain33/chain33/consensus/drivers/tendermint/state/fail"
 	"gitlab.33.cn/chain33/chain33/consensus/drivers/tendermint/types"
 )
 
@@ -18,7 +19,7 @@ type BlockExecutor struct {
 	db *CSStateDB
 
 	// execute the app against this
-	//proxyApp proxy.AppConnConsensus
+	proxyApp AppConnConsensus
 
 	// events
 	eventBus types.BlockEventPublisher
@@ -32,10 +33,10 @@ type BlockExecutor struct {
 
 // NewBlockExecutor returns a new BlockExecutor with a NopEventBus.
 // Call SetEventBus to provide one.
-func NewBlockExecutor(db *CSStateDB, logger log.Logger, evpool types.EvidencePool) *BlockExecutor {
+func NewBlockExecutor(db *CSStateDB, logger log.Logger, proxyApp AppConnConsensus, evpool types.EvidencePool) *BlockExecutor {
 	return &BlockExecutor{
-		db: db,
-		//proxyApp: proxyApp,
+		db:       db,
+		proxyApp: proxyApp,
 		eventBus: types.NopEventBus{},
 		//mempool:  mempool,
 		evpool: evpool,
@@ -50,11 +51,10 @@ func (blockExec *BlockExecutor) SetEventBus(eventBus types.BlockEventPublisher)
 }
 
 // ValidateBlock validates the given block against the given state.
-// If the block is invalid, it returns an error.
-// Validation does not mutate state, but does require historical information from the stateDB,
-// ie. to verify evidence from a validator at an old height.
+// If the block is invalid, it returns an error. Validation does not mutate
+// state.
 func (blockExec *BlockExecutor) ValidateBlock(s State, block *types.Block) error {
-	return validateBlock(blockExec.db, s, block)
+	return validateBlock(s, block, blockExec.evpool)
 }
 
 // ApplyBlock validates the block against the state, executes it against the app,
@@ -67,39 +67,54 @@ func (blockExec *BlockExecutor) ApplyBlock(s State, blockID types.BlockID, block
 	if err := blockExec.ValidateBlock(s, block); err != nil {
 		return s, ErrInvalidBlock(err)
 	}
-	/*
-		abciResponses, err := execBlockOnProxyApp(blockExec.logger, blockExec.proxyApp, block)
-		if err != nil {
-			return s, ErrProxyAppConn(err)
-		}
-	*/
-	//fail.Fail() // XXX
+
+	abciResponses, err := execBlockOnProxyApp(blockExec.logger, blockExec.proxyApp, block)
+	if err != nil {
+		return s, ErrProxyAppConn(err)
+	}
+
+	fail.Fail() // XXX
 
 	// save the results before we commit
-	//saveABCIResponses(blockExec.db, block.Height, abciResponses)
+	if err := blockExec.db.SaveABCIResponses(block.Header.Height, abciResponses); err != nil {
+		return s, fmt.Errorf("Commit failed for application: %v", err)
+	}
 
-	//fail.Fail() // XXX
+	fail.Fail() // XXX
 
 	// update the state with the block and responses
-	s, err := updateState(s, blockID, block)
+	s, err = updateState(s, blockID, block, abciResponses)
 	if err != nil {
 		return s, fmt.Errorf("Commit failed for application: %v", err)
 	}
 
+	// Persist the validator set and consensus params, so LoadValidators /
+	// LoadConsensusParams can walk back to whichever height last changed
+	// them. Validators decided this block don't take effect until
+	// nextHeight+1 (the one-block lead time), so that's the height their
+	// info is saved at; params take effect at nextHeight, one block
+	// sooner, so they're saved there instead.
+	nextHeight := block.Header.Height + 1
+	if err := blockExec.db.SaveValidatorsInfo(nextHeight+1, s.LastHeightValidatorsChanged, s.Validators); err != nil {
+		return s, fmt.Errorf("Commit failed for application: %v", err)
+	}
+	if err := blockExec.db.SaveConsensusParamsInfo(nextHeight, s.LastHeightConsensusParamsChanged, s.ConsensusParams); err != nil {
+		return s, fmt.Errorf("Commit failed for application: %v", err)
+	}
+
 	// lock mempool, commit state, update mempoool
-	/*
-		appHash, err := blockExec.Commit(block)
-		if err != nil {
-			return s, fmt.Errorf("Commit failed for application: %v", err)
-		}
-	*/
-	//fail.Fail() // XXX
+	appHash, err := blockExec.Commit(block)
+	if err != nil {
+		return s, fmt.Errorf("Commit failed for application: %v", err)
+	}
+
+	fail.Fail() // XXX
 
 	// update the app hash and save the state
-	//s.AppHash = appHash
+	s.AppHash = appHash
 	blockExec.db.SaveState(s)
 
-	//fail.Fail() // XXX
+	fail.Fail() // XXX
 
 	// Update evpool now that state is saved
 	// TODO: handle the crash/recover scenario
@@ -108,13 +123,21 @@ func (blockExec *BlockExecutor) ApplyBlock(s State, blockID types.BlockID, block
 
 	// events are fired after everything else
 	// NOTE: if we crash between Commit and Save, events wont be fired during replay
-	fireEvents(blockExec.logger, blockExec.eventBus, block /*, abciResponses*/)
+	fireEvents(blockExec.logger, blockExec.eventBus, block, abciResponses)
 
 	return s, nil
 }
 
-// updateState returns a new State updated according to the header and responses.
-func updateState(s State, blockID types.BlockID, block *types.Block) (State, error) {
+// Commit commits the app state and returns the resulting app hash. No
+// mempool is locked or updated.
+func (blockExec *BlockExecutor) Commit(block *types.Block) ([]byte, error) {
+	res := blockExec.proxyApp.Commit()
+	return res.Data, nil
+}
+
+// updateState returns a new State updated according to the header and the
+// responses produced by executing the block against the app.
+func updateState(s State, blockID types.BlockID, block *types.Block, abciResponses *ABCIResponses) (State, error) {
 
 	// copy the valset so we can apply changes from EndBlock
 	// and update s.LastValidators and s.Validators
@@ -123,6 +146,23 @@ func updateState(s State, blockID types.BlockID, block *types.Block) (State, err
 
 	// update the validator set with the latest abciResponses
 	lastHeightValsChanged := s.LastHeightValidatorsChanged
+	if len(abciResponses.EndBlock.ValidatorUpdates) > 0 {
+		if err := updateValidators(nextValSet, abciResponses.EndBlock.ValidatorUpdates); err != nil {
+			return s, fmt.Errorf("error changing validator set: %v", err)
+		}
+		// The validators are only effective two blocks from now, giving
+		// light clients one block of lead time to verify the change.
+		lastHeightValsChanged = block.Header.Height + 1 + 1
+	}
+
+	// Slash any validator whose evidence this block just committed by
+	// zeroing its voting power, same as any other validator-set change.
+	for _, ev := range block.Evidence.Evidence {
+		if err := slashValidator(nextValSet, ev.Address()); err != nil {
+			return s, fmt.Errorf("error slashing evidence validator: %v", err)
+		}
+		lastHeightValsChanged = block.Header.Height + 1 + 1
+	}
 
 	// Update validator accums and set state variables
 	nextValSet.IncrementAccum(1)
@@ -130,9 +170,13 @@ func updateState(s State, blockID types.BlockID, block *types.Block) (State, err
 	// update the params with the latest abciResponses
 	nextParams := s.ConsensusParams
 	lastHeightParamsChanged := s.LastHeightConsensusParamsChanged
+	if abciResponses.EndBlock.ConsensusParamUpdates != nil {
+		nextParams = s.ConsensusParams.Update(abciResponses.EndBlock.ConsensusParamUpdates)
+		lastHeightParamsChanged = block.Header.Height + 1
+	}
 
 	// NOTE: the AppHash has not been populated.
-	// It will be filled on state.Save.
+	// It will be filled in by ApplyBlock once Commit returns.
 	return State{
 		ChainID:                          s.ChainID,
 		LastBlockHeight:                  block.Header.Height,
@@ -144,35 +188,74 @@ func updateState(s State, blockID types.BlockID, block *types.Block) (State, err
 		LastHeightValidatorsChanged:      lastHeightValsChanged,
 		ConsensusParams:                  nextParams,
 		LastHeightConsensusParamsChanged: lastHeightParamsChanged,
-		LastResultsHash:                  nil,
+		LastResultsHash:                  abciResponses.ResultsHash(),
 		AppHash:                          nil,
 	}, nil
 }
 
+// updateValidators applies the validator diff returned by EndBlock to
+// validators in place: a zero VotingPower removes the validator, a known
+// address updates its power, and anything else is added as a new validator.
+func updateValidators(validators *types.ValidatorSet, updates []*types.Validator) error {
+	for _, v := range updates {
+		address := v.Address
+		power := v.VotingPower
+		_, validator := validators.GetByAddress(address)
+		if validator == nil {
+			if power == 0 {
+				continue
+			}
+			if err := validators.Add(v); err != nil {
+				return err
+			}
+			continue
+		}
+		if power == 0 {
+			if _, removed := validators.Remove(address); !removed {
+				return fmt.Errorf("failed to remove validator %X", address)
+			}
+			continue
+		}
+		if err := validators.Update(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// slashValidator zeroes the voting power of the validator at address. Unlike
+// a zero-power EndBlock update in updateValidators, the validator is kept in
+// the set. It's a no-op if the validator is already gone from the set.
+func slashValidator(validators *types.ValidatorSet, address []byte) error {
+	_, val := validators.GetByAddress(address)
+	if val == nil {
+		return nil
+	}
+	slashed := *val
+	slashed.VotingPower = 0
+	return validators.Update(&slashed)
+}
+
 // Fire NewBlock, NewBlockHeader.
 // Fire TxEvent for every tx.
 // NOTE: if Tendermint crashes before commit, some or all of these events may be published again.
-func fireEvents(logger log.Logger, eventBus types.BlockEventPublisher, block *types.Block /*, abciResponses *ABCIResponses*/) {
-	/*
-		// NOTE: do we still need this buffer ?
-		txEventBuffer := types.NewTxEventBuffer(eventBus, int(block.NumTxs))
-		for i, tx := range block.Data.Txs {
-			txEventBuffer.PublishEventTx(types.EventDataTx{types.TxResult{
-				Height: block.Height,
-				Index:  uint32(i),
-				Tx:     tx,
-				Result: *(abciResponses.DeliverTx[i]),
-			}})
-		}
-	*/
+func fireEvents(logger log.Logger, eventBus types.BlockEventPublisher, block *types.Block, abciResponses *ABCIResponses) {
+	txEventBuffer := types.NewTxEventBuffer(eventBus, len(block.Data.Txs))
+	for i, tx := range block.Data.Txs {
+		txEventBuffer.PublishEventTx(types.EventDataTx{TxResult: types.TxResult{
+			Height: block.Header.Height,
+			Index:  uint32(i),
+			Tx:     tx,
+			Result: *(abciResponses.DeliverTx[i]),
+		}})
+	}
+
 	eventBus.PublishEventNewBlock(types.EventDataNewBlock{block})
 	eventBus.PublishEventNewBlockHeader(types.EventDataNewBlockHeader{block.Header})
-	/*
-		err := txEventBuffer.Flush()
-		if err != nil {
-			logger.Error("Failed to flush event buffer", "err", err)
-		}
-	*/
+
+	if err := txEventBuffer.Flush(); err != nil {
+		logger.Error("Failed to flush event buffer", "err", err)
+	}
 }
 
 //----------------------------------------------------------------------------------------------------
@@ -0,0 +1,56 @@
+package state
+
+import (
+	"testing"
+
+	"gitlab.33.cn/chain33/chain33/consensus/drivers/tendermint/types"
+)
+
+func newTestValidator(address []byte, power int64) *types.Validator {
+	return &types.Validator{Address: address, VotingPower: power}
+}
+
+// TestSlashValidatorZeroesPower covers the chunk0-5 slashing path: a
+// validator named by committed evidence must have its voting power zeroed
+// in the next validator set, without removing or otherwise disturbing
+// validators not named by any evidence.
+func TestSlashValidatorZeroesPower(t *testing.T) {
+	offender := []byte("validator-A")
+	innocent := []byte("validator-B")
+
+	valSet := types.NewValidatorSet([]*types.Validator{
+		newTestValidator(offender, 10),
+		newTestValidator(innocent, 10),
+	})
+
+	if err := slashValidator(valSet, offender); err != nil {
+		t.Fatalf("unexpected error slashing validator: %v", err)
+	}
+
+	_, slashed := valSet.GetByAddress(offender)
+	if slashed == nil {
+		t.Fatal("slashed validator should still be present in the set, with zero power")
+	}
+	if slashed.VotingPower != 0 {
+		t.Fatalf("expected slashed validator's voting power to be 0, got %d", slashed.VotingPower)
+	}
+
+	_, other := valSet.GetByAddress(innocent)
+	if other == nil || other.VotingPower != 10 {
+		t.Fatal("slashing one validator must not affect another validator's power")
+	}
+}
+
+// TestSlashValidatorUnknownAddressIsNoOp ensures slashing an address that
+// isn't (or is no longer) in the validator set is a no-op rather than an
+// error, since the validator may already have been removed by a prior
+// EndBlock update in the same block.
+func TestSlashValidatorUnknownAddressIsNoOp(t *testing.T) {
+	valSet := types.NewValidatorSet([]*types.Validator{
+		newTestValidator([]byte("validator-A"), 10),
+	})
+
+	if err := slashValidator(valSet, []byte("not-a-validator")); err != nil {
+		t.Fatalf("expected no-op for an unknown address, got error: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package state
+
+import (
+	"testing"
+
+	"gitlab.33.cn/chain33/chain33/consensus/drivers/tendermint/types"
+)
+
+// TestUpdateStateValidatorChangeActivationWindow pins the exact height
+// arithmetic SaveValidatorsInfo/LoadValidators depend on: a validator-set
+// change decided at height H must not take effect until H+2 (one block of
+// lead time for light clients), while a consensus-param change decided at
+// the same height takes effect one block sooner, at H+1. This is the same
+// class of off-by-one that had to be corrected mid-series once already
+// (see the chunk0-4 fix commit), so it's pinned directly rather than only
+// indirectly through ApplyBlock.
+func TestUpdateStateValidatorChangeActivationWindow(t *testing.T) {
+	const height = int64(10)
+
+	existing := newTestValidator([]byte("validator-A"), 10)
+	incoming := newTestValidator([]byte("validator-B"), 5)
+
+	s := State{
+		Validators:                       types.NewValidatorSet([]*types.Validator{existing}),
+		ConsensusParams:                  types.ConsensusParams{},
+		LastHeightValidatorsChanged:      height,
+		LastHeightConsensusParamsChanged: height,
+	}
+	block := &types.Block{Header: types.Header{Height: height}}
+	abciResponses := &ABCIResponses{
+		DeliverTx: make([]*types.ResponseDeliverTx, 0),
+		EndBlock: &types.ResponseEndBlock{
+			ValidatorUpdates:      []*types.Validator{incoming},
+			ConsensusParamUpdates: &types.ConsensusParams{},
+		},
+	}
+
+	next, err := updateState(s, types.BlockID{}, block, abciResponses)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := height + 1 + 1; next.LastHeightValidatorsChanged != want {
+		t.Fatalf("expected LastHeightValidatorsChanged to be %d, got %d", want, next.LastHeightValidatorsChanged)
+	}
+	if want := height + 1; next.LastHeightConsensusParamsChanged != want {
+		t.Fatalf("expected LastHeightConsensusParamsChanged to be %d, got %d", want, next.LastHeightConsensusParamsChanged)
+	}
+
+	// The new validator must be present immediately in the returned set
+	// (updateValidators applies the diff right away); it's only the
+	// *activation height recorded for LoadValidators* that's delayed, not
+	// the value stored in state itself.
+	if _, v := next.Validators.GetByAddress(incoming.Address); v == nil {
+		t.Fatal("expected the incoming validator to be present in the updated set")
+	}
+}
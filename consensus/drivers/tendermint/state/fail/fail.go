@@ -0,0 +1,38 @@
+// Package fail provides crash points that can be armed from outside the
+// process so tests can exercise ApplyBlock's atomic boundaries (validate,
+// save responses, update state, commit, save state, evpool.Update) one at a
+// time and verify the executor recovers correctly from a crash at each of
+// them. In normal operation Fail is a no-op.
+package fail
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// FailureErr is the value Fail panics with when it triggers.
+var FailureErr = fmt.Errorf("fail point triggered")
+
+var callIndexToFail = -1
+
+func init() {
+	if s := os.Getenv("CHAIN33_FAIL_TEST_INDEX"); s != "" {
+		if i, err := strconv.Atoi(s); err == nil {
+			callIndexToFail = i
+		}
+	}
+}
+
+var globalCallIndex int
+
+// Fail panics the first time it is called with a call index matching
+// CHAIN33_FAIL_TEST_INDEX. Every other call (including all calls when the
+// env var is unset) just advances the index and returns.
+func Fail() {
+	index := globalCallIndex
+	globalCallIndex++
+	if index == callIndexToFail {
+		panic(FailureErr)
+	}
+}
@@ -0,0 +1,41 @@
+package fail
+
+import "testing"
+
+// reset restores Fail's internal counters as if CHAIN33_FAIL_TEST_INDEX had
+// selected index (or had been unset, for index < 0), so each test case
+// starts from a clean slate regardless of test order.
+func reset(index int) {
+	callIndexToFail = index
+	globalCallIndex = 0
+}
+
+func TestFailNoOpWhenUnset(t *testing.T) {
+	reset(-1)
+	for i := 0; i < 10; i++ {
+		Fail() // must never panic
+	}
+}
+
+func TestFailTriggersAtSelectedIndex(t *testing.T) {
+	reset(2)
+
+	defer func() {
+		r := recover()
+		if r != FailureErr {
+			t.Fatalf("expected Fail to panic with FailureErr at call index 2, got %v", r)
+		}
+	}()
+
+	Fail() // index 0
+	Fail() // index 1
+	Fail() // index 2 -> panics
+	t.Fatal("Fail did not panic at the selected index")
+}
+
+func TestFailDoesNotTriggerBeforeSelectedIndex(t *testing.T) {
+	reset(2)
+	Fail() // index 0
+	Fail() // index 1
+	// must not have panicked yet
+}
@@ -0,0 +1,49 @@
+package state
+
+import (
+	log "github.com/inconshreveable/log15"
+	"gitlab.33.cn/chain33/chain33/consensus/drivers/tendermint/types"
+)
+
+// AppConnConsensus is the consensus-facing connection to the app. It bridges
+// block execution to the chain33 executor pipeline the same way an ABCI app
+// would be driven: one BeginBlock, one DeliverTx per transaction, one
+// EndBlock, then a Commit once the block has been fully applied.
+type AppConnConsensus interface {
+	Info() types.ResponseInfo
+	BeginBlock(types.RequestBeginBlock) types.ResponseBeginBlock
+	DeliverTx(tx types.Tx) types.ResponseDeliverTx
+	EndBlock(types.RequestEndBlock) types.ResponseEndBlock
+	Commit() types.ResponseCommit
+}
+
+// execBlockOnProxyApp executes block against the app connection, collecting
+// a DeliverTx response for every transaction plus the begin/end-block
+// results, without committing anything.
+func execBlockOnProxyApp(logger log.Logger, proxyAppConn AppConnConsensus, block *types.Block) (*ABCIResponses, error) {
+	abciResponses := NewABCIResponses(block)
+
+	beginBlock := proxyAppConn.BeginBlock(types.RequestBeginBlock{
+		Hash:   block.Hash(),
+		Header: block.Header,
+	})
+	abciResponses.BeginBlock = &beginBlock
+
+	validTxs, invalidTxs := 0, 0
+	for i, tx := range block.Data.Txs {
+		resp := proxyAppConn.DeliverTx(tx)
+		if resp.Code == 0 {
+			validTxs++
+		} else {
+			invalidTxs++
+		}
+		abciResponses.DeliverTx[i] = &resp
+	}
+
+	endBlock := proxyAppConn.EndBlock(types.RequestEndBlock{Height: block.Header.Height})
+	abciResponses.EndBlock = &endBlock
+
+	logger.Info("Executed block", "height", block.Header.Height, "validTxs", validTxs, "invalidTxs", invalidTxs)
+
+	return abciResponses, nil
+}
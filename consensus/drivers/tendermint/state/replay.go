@@ -0,0 +1,123 @@
+package state
+
+import (
+	"fmt"
+
+	"gitlab.33.cn/chain33/chain33/consensus/drivers/tendermint/types"
+)
+
+// BlockStore is the subset of the block store Handshake needs to find the
+// chain tip and fetch individual blocks for replay.
+type BlockStore interface {
+	Height() int64
+	LoadBlock(height int64) *types.Block
+	LoadBlockID(height int64) types.BlockID
+}
+
+// Handshake brings the app, the saved State, and the block store back in
+// step on startup. A crash can land in a few places: before Commit (app and
+// State are both behind the store by the same amount), between Commit and
+// SaveState (the app is one block ahead of State), or the app's own storage
+// falling behind State and the store independently of any consensus crash.
+// Handshake detects whichever of these happened and replays whatever's
+// missing so all three converge.
+func Handshake(s State, blockStore BlockStore, blockExec *BlockExecutor) (State, error) {
+	storeHeight := blockStore.Height()
+	appHeight := blockExec.proxyApp.Info().LastBlockHeight
+
+	if appHeight > storeHeight {
+		return s, fmt.Errorf("app is ahead of the block store: app height %d, store height %d", appHeight, storeHeight)
+	}
+	if s.LastBlockHeight > storeHeight {
+		return s, fmt.Errorf("saved state is ahead of the block store: state height %d, store height %d", s.LastBlockHeight, storeHeight)
+	}
+
+	// State and the block store already agree; the app alone fell behind
+	// (its own storage lagged or was reverted independently of consensus).
+	// There's nothing left to decide for these blocks, so replay them
+	// straight into the app instead of running them back through
+	// ApplyBlock, which would re-validate and re-save state/evidence that's
+	// already settled.
+	if appHeight < s.LastBlockHeight {
+		for height := appHeight + 1; height <= s.LastBlockHeight; height++ {
+			block := blockStore.LoadBlock(height)
+			if _, err := execCommitBlock(blockExec, block); err != nil {
+				return s, fmt.Errorf("error catching up app to height %d: %v", height, err)
+			}
+		}
+	}
+
+	// The app already committed the next block but the crash happened
+	// before SaveState recorded it. Catch the state up using the ABCI
+	// responses ApplyBlock already persisted, instead of re-delivering
+	// the block's txs to the app a second time.
+	if appHeight == s.LastBlockHeight+1 {
+		var err error
+		s, err = replayAppliedBlock(s, blockStore, blockExec)
+		if err != nil {
+			return s, fmt.Errorf("error catching up state to height %d: %v", appHeight, err)
+		}
+	}
+
+	// Whatever's left between state and the block store tip, the app
+	// hasn't executed at all: replay it through full ApplyBlock so the
+	// app, the state, and the validator/evidence bookkeeping all converge
+	// together.
+	for height := s.LastBlockHeight + 1; height <= storeHeight; height++ {
+		block := blockStore.LoadBlock(height)
+		blockID := blockStore.LoadBlockID(height)
+
+		var err error
+		s, err = blockExec.ApplyBlock(s, blockID, block)
+		if err != nil {
+			return s, fmt.Errorf("error replaying block %d: %v", height, err)
+		}
+	}
+
+	return s, nil
+}
+
+// execCommitBlock executes and commits a single block against the app only,
+// without touching state, the state DB, or the evidence pool. It's the
+// lighter path Handshake uses when the app alone is behind a state/block
+// store pair that already agree with each other.
+func execCommitBlock(blockExec *BlockExecutor, block *types.Block) ([]byte, error) {
+	if _, err := execBlockOnProxyApp(blockExec.logger, blockExec.proxyApp, block); err != nil {
+		return nil, err
+	}
+	return blockExec.Commit(block)
+}
+
+// replayAppliedBlock advances s past the one block the app already
+// committed (s.LastBlockHeight+1), reusing the ABCIResponses ApplyBlock
+// saved before the crash rather than re-executing the block against the
+// app, which has already seen it.
+func replayAppliedBlock(s State, blockStore BlockStore, blockExec *BlockExecutor) (State, error) {
+	height := s.LastBlockHeight + 1
+	block := blockStore.LoadBlock(height)
+	blockID := blockStore.LoadBlockID(height)
+
+	abciResponses, err := blockExec.db.LoadABCIResponses(height)
+	if err != nil {
+		return s, fmt.Errorf("no saved ABCI responses for height %d: %v", height, err)
+	}
+
+	s, err = updateState(s, blockID, block, abciResponses)
+	if err != nil {
+		return s, err
+	}
+
+	nextHeight := height + 1
+	if err := blockExec.db.SaveValidatorsInfo(nextHeight+1, s.LastHeightValidatorsChanged, s.Validators); err != nil {
+		return s, err
+	}
+	if err := blockExec.db.SaveConsensusParamsInfo(nextHeight, s.LastHeightConsensusParamsChanged, s.ConsensusParams); err != nil {
+		return s, err
+	}
+
+	s.AppHash = blockExec.proxyApp.Info().LastBlockAppHash
+	blockExec.db.SaveState(s)
+	blockExec.evpool.Update(block)
+
+	return s, nil
+}
@@ -0,0 +1,138 @@
+package state
+
+import (
+	"strings"
+	"testing"
+
+	log "github.com/inconshreveable/log15"
+	"gitlab.33.cn/chain33/chain33/consensus/drivers/tendermint/types"
+)
+
+type fakeBlockStore struct {
+	height int64
+}
+
+func (s *fakeBlockStore) Height() int64 { return s.height }
+
+func (s *fakeBlockStore) LoadBlock(height int64) *types.Block {
+	return &types.Block{Header: types.Header{Height: height}}
+}
+
+func (s *fakeBlockStore) LoadBlockID(height int64) types.BlockID {
+	return types.BlockID{}
+}
+
+// fakeAppConn tracks every height committed to it, so tests can assert which
+// blocks Handshake actually replayed into the app.
+type fakeAppConn struct {
+	lastBlockHeight int64
+	committed       []int64
+}
+
+func (a *fakeAppConn) Info() types.ResponseInfo {
+	return types.ResponseInfo{LastBlockHeight: a.lastBlockHeight}
+}
+
+func (a *fakeAppConn) BeginBlock(types.RequestBeginBlock) types.ResponseBeginBlock {
+	return types.ResponseBeginBlock{}
+}
+
+func (a *fakeAppConn) DeliverTx(types.Tx) types.ResponseDeliverTx {
+	return types.ResponseDeliverTx{}
+}
+
+func (a *fakeAppConn) EndBlock(req types.RequestEndBlock) types.ResponseEndBlock {
+	a.committed = append(a.committed, req.Height)
+	return types.ResponseEndBlock{}
+}
+
+func (a *fakeAppConn) Commit() types.ResponseCommit {
+	a.lastBlockHeight++
+	return types.ResponseCommit{}
+}
+
+// TestHandshakeAlreadyInSync covers the case where state, the block store,
+// and the app all already agree: Handshake must return the given State
+// unchanged and must not need to touch the db (which is nil here) to get
+// there.
+func TestHandshakeAlreadyInSync(t *testing.T) {
+	s := State{LastBlockHeight: 10}
+	blockExec := &BlockExecutor{proxyApp: &fakeAppConn{lastBlockHeight: 10}}
+
+	got, err := Handshake(s, &fakeBlockStore{height: 10}, blockExec)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.LastBlockHeight != 10 {
+		t.Fatalf("expected state to stay at height 10, got %d", got.LastBlockHeight)
+	}
+}
+
+// TestHandshakeRejectsAppAheadOfStore ensures an app that is ahead of the
+// block store is rejected, and that the error labels the app and store
+// heights correctly.
+func TestHandshakeRejectsAppAheadOfStore(t *testing.T) {
+	s := State{LastBlockHeight: 5}
+	blockExec := &BlockExecutor{proxyApp: &fakeAppConn{lastBlockHeight: 8}}
+
+	_, err := Handshake(s, &fakeBlockStore{height: 5}, blockExec)
+	if err == nil {
+		t.Fatal("expected an error when the app is ahead of the block store")
+	}
+	if !strings.Contains(err.Error(), "app height 8") || !strings.Contains(err.Error(), "store height 5") {
+		t.Fatalf("error message mislabels heights: %v", err)
+	}
+}
+
+// TestHandshakeRejectsStateAheadOfStore ensures a saved State past the
+// block store's tip (which should never happen) is reported using
+// s.LastBlockHeight, not mislabeled as the store's own height.
+func TestHandshakeRejectsStateAheadOfStore(t *testing.T) {
+	s := State{LastBlockHeight: 12}
+	blockExec := &BlockExecutor{proxyApp: &fakeAppConn{lastBlockHeight: 9}}
+
+	_, err := Handshake(s, &fakeBlockStore{height: 9}, blockExec)
+	if err == nil {
+		t.Fatal("expected an error when the saved state is ahead of the block store")
+	}
+	if !strings.Contains(err.Error(), "state height 12") || !strings.Contains(err.Error(), "store height 9") {
+		t.Fatalf("error message mislabels heights: %v", err)
+	}
+}
+
+// TestHandshakeReplaysIntoLaggingApp covers the third divergence case: state
+// and the block store already agree, but the app itself fell behind (e.g.
+// its own storage lagged or was reverted independently of consensus).
+// Handshake must drive the app through the missing blocks via
+// execCommitBlock rather than silently returning s unchanged.
+func TestHandshakeReplaysIntoLaggingApp(t *testing.T) {
+	s := State{LastBlockHeight: 10}
+	app := &fakeAppConn{lastBlockHeight: 8}
+	blockExec := &BlockExecutor{proxyApp: app, logger: log.New()}
+
+	got, err := Handshake(s, &fakeBlockStore{height: 10}, blockExec)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.LastBlockHeight != 10 {
+		t.Fatalf("expected state to stay at height 10, got %d", got.LastBlockHeight)
+	}
+	if want := []int64{9, 10}; !int64SlicesEqual(app.committed, want) {
+		t.Fatalf("expected the app to replay heights %v, got %v", want, app.committed)
+	}
+	if app.lastBlockHeight != 10 {
+		t.Fatalf("expected the app to catch up to height 10, got %d", app.lastBlockHeight)
+	}
+}
+
+func int64SlicesEqual(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
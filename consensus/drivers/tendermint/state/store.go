@@ -0,0 +1,152 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"gitlab.33.cn/chain33/chain33/consensus/drivers/tendermint/types"
+)
+
+func calcABCIResponsesKey(height int64) []byte {
+	return []byte(fmt.Sprintf("abciResponsesKey:%v", height))
+}
+
+func calcValidatorsKey(height int64) []byte {
+	return []byte(fmt.Sprintf("validatorsKey:%v", height))
+}
+
+func calcConsensusParamsKey(height int64) []byte {
+	return []byte(fmt.Sprintf("consensusParamsKey:%v", height))
+}
+
+// SaveABCIResponses persists the per-tx and per-block results for height so
+// LoadABCIResponses can serve replay and tx-result queries without
+// re-executing the block.
+func (db *CSStateDB) SaveABCIResponses(height int64, abciResponses *ABCIResponses) error {
+	bytes, err := json.Marshal(abciResponses)
+	if err != nil {
+		return err
+	}
+	return db.Set(calcABCIResponsesKey(height), bytes)
+}
+
+// LoadABCIResponses loads the ABCIResponses saved for height.
+func (db *CSStateDB) LoadABCIResponses(height int64) (*ABCIResponses, error) {
+	buf := db.Get(calcABCIResponsesKey(height))
+	if len(buf) == 0 {
+		return nil, fmt.Errorf("no ABCI responses saved for height %v", height)
+	}
+	abciResponses := new(ABCIResponses)
+	if err := json.Unmarshal(buf, abciResponses); err != nil {
+		return nil, fmt.Errorf("LoadABCIResponses: Data has been corrupted or its spec has changed: %v", err)
+	}
+	return abciResponses, nil
+}
+
+// LoadABCIResponses is a package-level convenience wrapper so callers that
+// only hold a *CSStateDB (e.g. RPC handlers) don't need a *BlockExecutor.
+func LoadABCIResponses(db *CSStateDB, height int64) (*ABCIResponses, error) {
+	return db.LoadABCIResponses(height)
+}
+
+// ValidatorsInfo represents the latest validator set, or the last height it
+// changed, for a given height. Only the height the set actually changed at
+// stores the full ValidatorSet; every other height just points back to it,
+// so LoadValidators can walk back to find it without storing it redundantly
+// at every height.
+type ValidatorsInfo struct {
+	ValidatorSet      *types.ValidatorSet
+	LastHeightChanged int64
+}
+
+// ConsensusParamsInfo is the ConsensusParams equivalent of ValidatorsInfo.
+type ConsensusParamsInfo struct {
+	ConsensusParams   types.ConsensusParams
+	LastHeightChanged int64
+}
+
+// SaveValidatorsInfo persists the validator set effective as of height.
+// lastHeightChanged must be the height the set last actually changed at; if
+// it equals height the full set is stored, otherwise only a pointer back to
+// lastHeightChanged is, since the set there is unchanged.
+func (db *CSStateDB) SaveValidatorsInfo(height, lastHeightChanged int64, valSet *types.ValidatorSet) error {
+	if lastHeightChanged > height {
+		return fmt.Errorf("lastHeightChanged cannot be greater than height")
+	}
+	info := &ValidatorsInfo{LastHeightChanged: lastHeightChanged}
+	if lastHeightChanged == height {
+		info.ValidatorSet = valSet
+	}
+	bytes, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return db.Set(calcValidatorsKey(height), bytes)
+}
+
+// LoadValidators returns the validator set effective at height, walking
+// back to the height it last changed at if it wasn't stored directly at
+// height. The evidence pool's AddEvidence uses this to verify evidence
+// against the validator set that was active at the height it is about.
+func (db *CSStateDB) LoadValidators(height int64) (*types.ValidatorSet, error) {
+	info, err := db.loadValidatorsInfo(height)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't find validators at height %d: %v", height, err)
+	}
+	if info.ValidatorSet == nil {
+		lastHeightChanged := info.LastHeightChanged
+		info, err = db.loadValidatorsInfo(lastHeightChanged)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't find validators at height %d: %v", lastHeightChanged, err)
+		}
+	}
+	return info.ValidatorSet, nil
+}
+
+func (db *CSStateDB) loadValidatorsInfo(height int64) (*ValidatorsInfo, error) {
+	buf := db.Get(calcValidatorsKey(height))
+	if len(buf) == 0 {
+		return nil, fmt.Errorf("no validator set found")
+	}
+	info := new(ValidatorsInfo)
+	if err := json.Unmarshal(buf, info); err != nil {
+		return nil, fmt.Errorf("data has been corrupted or its spec has changed: %v", err)
+	}
+	return info, nil
+}
+
+// SaveConsensusParamsInfo persists the consensus params effective as of
+// height, using the same last-changed-pointer scheme as SaveValidatorsInfo.
+func (db *CSStateDB) SaveConsensusParamsInfo(height, lastHeightChanged int64, params types.ConsensusParams) error {
+	if lastHeightChanged > height {
+		return fmt.Errorf("lastHeightChanged cannot be greater than height")
+	}
+	info := &ConsensusParamsInfo{LastHeightChanged: lastHeightChanged}
+	if lastHeightChanged == height {
+		info.ConsensusParams = params
+	}
+	bytes, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return db.Set(calcConsensusParamsKey(height), bytes)
+}
+
+// LoadConsensusParams returns the consensus params effective at height,
+// walking back to the height they last changed at if needed.
+func (db *CSStateDB) LoadConsensusParams(height int64) (types.ConsensusParams, error) {
+	empty := types.ConsensusParams{}
+
+	buf := db.Get(calcConsensusParamsKey(height))
+	if len(buf) == 0 {
+		return empty, fmt.Errorf("no consensus params found at height %d", height)
+	}
+	info := new(ConsensusParamsInfo)
+	if err := json.Unmarshal(buf, info); err != nil {
+		return empty, fmt.Errorf("data has been corrupted or its spec has changed: %v", err)
+	}
+	if info.LastHeightChanged == height {
+		return info.ConsensusParams, nil
+	}
+	return db.LoadConsensusParams(info.LastHeightChanged)
+}
@@ -0,0 +1,30 @@
+package state
+
+import (
+	"fmt"
+
+	"gitlab.33.cn/chain33/chain33/consensus/drivers/tendermint/types"
+)
+
+// validateBlock runs the checks ApplyBlock requires before executing a
+// block: the header must extend the chain at the expected height, and
+// every piece of evidence the block carries must already be known to the
+// evidence pool (gossiped and verified ahead of time, via AddEvidence) and
+// not already committed by an earlier block.
+func validateBlock(s State, block *types.Block, evpool types.EvidencePool) error {
+	if block.Header.Height != s.LastBlockHeight+1 {
+		return fmt.Errorf("wrong Block.Header.Height. Expected %v, got %v",
+			s.LastBlockHeight+1, block.Header.Height)
+	}
+
+	for _, ev := range block.Evidence.Evidence {
+		if evpool.IsCommitted(ev) {
+			return fmt.Errorf("block contains already-committed evidence: %v", ev)
+		}
+		if !evpool.IsPending(ev) {
+			return fmt.Errorf("block contains unknown evidence: %v", ev)
+		}
+	}
+
+	return nil
+}
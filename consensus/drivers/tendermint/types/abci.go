@@ -0,0 +1,57 @@
+package types
+
+// KVPair is a generic tag emitted by the app while executing a block, e.g.
+// for indexing. It mirrors the tag shape used throughout the ABCI-style
+// request/response pairs below.
+type KVPair struct {
+	Key   []byte
+	Value []byte
+}
+
+// RequestBeginBlock is delivered to the app connection at the start of
+// block execution.
+type RequestBeginBlock struct {
+	Hash   []byte
+	Header Header
+}
+
+// ResponseBeginBlock is the app's reply to RequestBeginBlock.
+type ResponseBeginBlock struct {
+	Tags []KVPair
+}
+
+// RequestEndBlock is delivered to the app connection once every tx in the
+// block has been executed.
+type RequestEndBlock struct {
+	Height int64
+}
+
+// ResponseEndBlock carries the validator set and consensus param diffs the
+// app wants applied, taking effect with a delayed-activation window (see
+// updateState).
+type ResponseEndBlock struct {
+	ValidatorUpdates      []*Validator
+	ConsensusParamUpdates *ConsensusParams
+	Tags                  []KVPair
+}
+
+// ResponseDeliverTx is the app's result for a single transaction.
+type ResponseDeliverTx struct {
+	Code uint32
+	Data []byte
+	Log  string
+	Tags []KVPair
+}
+
+// ResponseCommit is the app's reply once it has persisted the block's state
+// changes; Data is the resulting app hash.
+type ResponseCommit struct {
+	Data []byte
+}
+
+// ResponseInfo reports the app's last fully-committed height, used by
+// Handshake to detect and replay whatever the app is missing on startup.
+type ResponseInfo struct {
+	LastBlockHeight  int64
+	LastBlockAppHash []byte
+}
@@ -0,0 +1,71 @@
+package types
+
+import (
+	"crypto/sha256"
+	"fmt"
+)
+
+// Evidence represents a discrete piece of evidence of validator
+// misbehaviour, verifiable by anyone against that validator's signature at
+// the height the evidence is about.
+type Evidence interface {
+	Height() int64
+	Address() []byte
+	Hash() []byte
+	Equal(Evidence) bool
+	String() string
+}
+
+// EvidencePool is what BlockExecutor and the consensus reactor use to
+// submit, pull, and settle evidence of validator misbehaviour. AddEvidence
+// verifies evidence before accepting it; PendingEvidence lets consensus
+// pull verified-but-uncommitted evidence into a block it's proposing;
+// Update marks a block's evidence committed and prunes expired evidence.
+type EvidencePool interface {
+	AddEvidence(Evidence) error
+	PendingEvidence(maxNum int) []Evidence
+	Update(block *Block)
+	IsPending(Evidence) bool
+	IsCommitted(Evidence) bool
+}
+
+// DuplicateVoteEvidence carries two conflicting votes cast by the same
+// validator at the same height and round, proof that the validator
+// double-signed.
+type DuplicateVoteEvidence struct {
+	ValidatorAddress []byte
+	VoteA            *Vote
+	VoteB            *Vote
+}
+
+// Height is the height the conflicting votes were cast at.
+func (dve *DuplicateVoteEvidence) Height() int64 {
+	return dve.VoteA.Height
+}
+
+// Address is the offending validator's address.
+func (dve *DuplicateVoteEvidence) Address() []byte {
+	return dve.ValidatorAddress
+}
+
+// Hash uniquely identifies this piece of evidence.
+func (dve *DuplicateVoteEvidence) Hash() []byte {
+	h := sha256.New()
+	h.Write(dve.ValidatorAddress)
+	h.Write(dve.VoteA.Hash())
+	h.Write(dve.VoteB.Hash())
+	return h.Sum(nil)
+}
+
+// Equal reports whether other is the same duplicate-vote evidence.
+func (dve *DuplicateVoteEvidence) Equal(other Evidence) bool {
+	o, ok := other.(*DuplicateVoteEvidence)
+	if !ok {
+		return false
+	}
+	return string(dve.Hash()) == string(o.Hash())
+}
+
+func (dve *DuplicateVoteEvidence) String() string {
+	return fmt.Sprintf("DuplicateVoteEvidence{%X voted twice at height %d}", dve.ValidatorAddress, dve.Height())
+}
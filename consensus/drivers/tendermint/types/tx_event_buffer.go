@@ -0,0 +1,48 @@
+package types
+
+// TxEventBuffer batches EventDataTx messages so a block's worth of tx
+// events can be flushed to the BlockEventPublisher atomically, instead of
+// publishing (and potentially blocking on) one event per tx as the block
+// is executed.
+type TxEventBuffer struct {
+	next     BlockEventPublisher
+	capacity int
+	events   []EventDataTx
+}
+
+// NewTxEventBuffer returns a TxEventBuffer that flushes to next when an
+// event arrives while it already holds capacity events, or whenever Flush
+// is called. A capacity <= 0 never flushes on its own.
+func NewTxEventBuffer(next BlockEventPublisher, capacity int) *TxEventBuffer {
+	return &TxEventBuffer{
+		next:     next,
+		capacity: capacity,
+		events:   make([]EventDataTx, 0, capacity),
+	}
+}
+
+// Length returns the number of events currently buffered.
+func (b *TxEventBuffer) Length() int {
+	return len(b.events)
+}
+
+// PublishEventTx buffers a tx event, flushing first if the buffer is full.
+func (b *TxEventBuffer) PublishEventTx(e EventDataTx) error {
+	if b.capacity > 0 && len(b.events) >= b.capacity {
+		if err := b.Flush(); err != nil {
+			return err
+		}
+	}
+	b.events = append(b.events, e)
+	return nil
+}
+
+// Flush publishes every buffered event to the underlying publisher and
+// empties the buffer.
+func (b *TxEventBuffer) Flush() error {
+	for _, e := range b.events {
+		b.next.PublishEventTx(e)
+	}
+	b.events = b.events[:0]
+	return nil
+}